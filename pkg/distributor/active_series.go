@@ -0,0 +1,275 @@
+package distributor
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/weaveworks/common/instrument"
+
+	"github.com/cortexproject/cortex/pkg/cardinality"
+	"github.com/cortexproject/cortex/pkg/ingester/client"
+	ingester_client "github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/ring"
+	grpc_util "github.com/cortexproject/cortex/pkg/util/grpc"
+)
+
+// ActiveSeries queries all ingesters for the series currently in their head that match
+// matchers, and returns the deduplicated set across the replication set. This is used to
+// let operators see which series are actively being ingested for a tenant, which is
+// invaluable when debugging a sudden cardinality spike.
+func (d *Distributor) ActiveSeries(ctx context.Context, matchers ...*labels.Matcher) (*cardinality.ActiveSeriesResponse, error) {
+	var resp *cardinality.ActiveSeriesResponse
+	err := instrument.CollectedRequest(ctx, "Distributor.ActiveSeries", queryDuration, instrument.ErrorCode, func(ctx context.Context) error {
+		req, err := ingester_client.ToActiveSeriesRequest(matchers)
+		if err != nil {
+			return err
+		}
+
+		replicationSets, err := d.GetIngestersForMetadata(ctx)
+		if err != nil {
+			return err
+		}
+
+		perSetResults, err := forReplicationSets(ctx, replicationSets, func(ctx context.Context, set ring.ReplicationSet) (interface{}, error) {
+			return d.queryActiveSeriesForSet(ctx, set, req)
+		})
+		if err != nil {
+			return err
+		}
+
+		sets := make([][]labels.Labels, len(perSetResults))
+		for i, r := range perSetResults {
+			sets[i] = r.([]labels.Labels)
+		}
+		resp = &cardinality.ActiveSeriesResponse{Series: dedupeLabelSets(sets)}
+		return nil
+	})
+	return resp, err
+}
+
+// ActiveNativeHistogramMetrics queries all ingesters for the native histogram series
+// currently in their head that match matchers, and returns the deduplicated set across
+// the replication set, along with per-metric bucket counts.
+func (d *Distributor) ActiveNativeHistogramMetrics(ctx context.Context, matchers ...*labels.Matcher) (*cardinality.ActiveNativeHistogramMetricsResponse, error) {
+	var resp *cardinality.ActiveNativeHistogramMetricsResponse
+	err := instrument.CollectedRequest(ctx, "Distributor.ActiveNativeHistogramMetrics", queryDuration, instrument.ErrorCode, func(ctx context.Context) error {
+		req, err := ingester_client.ToActiveSeriesRequest(matchers)
+		if err != nil {
+			return err
+		}
+
+		replicationSets, err := d.GetIngestersForMetadata(ctx)
+		if err != nil {
+			return err
+		}
+
+		perSetResults, err := forReplicationSets(ctx, replicationSets, func(ctx context.Context, set ring.ReplicationSet) (interface{}, error) {
+			return d.queryActiveNativeHistogramMetricsForSet(ctx, set, req)
+		})
+		if err != nil {
+			return err
+		}
+
+		sets := make([][]*cardinality.ActiveNativeHistogramMetric, len(perSetResults))
+		for i, r := range perSetResults {
+			sets[i] = r.([]*cardinality.ActiveNativeHistogramMetric)
+		}
+		resp = &cardinality.ActiveNativeHistogramMetricsResponse{Metrics: mergeActiveNativeHistogramMetrics(sets)}
+		return nil
+	})
+	return resp, err
+}
+
+// queryActiveSeriesForSet streams active series from every ingester in replicationSet in
+// parallel and returns their deduplicated union. Each ingester's callback returns its own
+// slice rather than writing into a shared map, since replicationSet.Do invokes it once per
+// ingester concurrently; the merge happens here, over the slices Do returned, so no
+// callback that is still running touches the merged result.
+func (d *Distributor) queryActiveSeriesForSet(ctx context.Context, replicationSet ring.ReplicationSet, req *client.ActiveSeriesRequest) ([]labels.Labels, error) {
+	results, err := replicationSet.Do(ctx, d.cfg.ExtraQueryDelay, func(ctx context.Context, ing *ring.IngesterDesc) (interface{}, error) {
+		c, err := d.ingesterPool.GetClientFor(ing.Addr)
+		if err != nil {
+			return nil, err
+		}
+
+		stream, err := c.(ingester_client.IngesterClient).ActiveSeries(ctx, req)
+		ingesterQueries.WithLabelValues(ing.Addr).Inc()
+		if err != nil {
+			ingesterQueryFailures.WithLabelValues(ing.Addr).Inc()
+			return nil, err
+		}
+		defer stream.CloseSend() //nolint:errcheck
+
+		var series []labels.Labels
+		for {
+			msg, err := stream.Recv()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				if !grpc_util.IsGRPCContextCanceled(err) {
+					ingesterQueryFailures.WithLabelValues(ing.Addr).Inc()
+				}
+				return nil, err
+			}
+
+			for _, la := range msg.Metric {
+				series = append(series, client.FromLabelAdaptersToLabels(la))
+			}
+		}
+		return series, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sets := make([][]labels.Labels, len(results))
+	for i, r := range results {
+		sets[i] = r.([]labels.Labels)
+	}
+	return dedupeLabelSets(sets), nil
+}
+
+// dedupeLabelSets merges zero or more label-set slices - one per ingester or per
+// replication set - into a single list of series deduplicated by label key.
+func dedupeLabelSets(sets [][]labels.Labels) []labels.Labels {
+	seen := map[string]labels.Labels{}
+	for _, set := range sets {
+		for _, lbls := range set {
+			seen[lbls.String()] = lbls
+		}
+	}
+
+	series := make([]labels.Labels, 0, len(seen))
+	for _, lbls := range seen {
+		series = append(series, lbls)
+	}
+	return series
+}
+
+// queryActiveNativeHistogramMetricsForSet streams active native histogram series from
+// every ingester in replicationSet in parallel and returns their deduplicated union.
+// Replicas report the same bucket count for a given series, so on a duplicate label key
+// the first value seen is kept rather than summed. As with queryActiveSeriesForSet, each
+// ingester's callback returns its own slice so the merge can happen after Do returns,
+// instead of writing into a map shared across concurrent callback invocations.
+func (d *Distributor) queryActiveNativeHistogramMetricsForSet(ctx context.Context, replicationSet ring.ReplicationSet, req *client.ActiveSeriesRequest) ([]*cardinality.ActiveNativeHistogramMetric, error) {
+	results, err := replicationSet.Do(ctx, d.cfg.ExtraQueryDelay, func(ctx context.Context, ing *ring.IngesterDesc) (interface{}, error) {
+		c, err := d.ingesterPool.GetClientFor(ing.Addr)
+		if err != nil {
+			return nil, err
+		}
+
+		stream, err := c.(ingester_client.IngesterClient).ActiveSeries(ctx, req)
+		ingesterQueries.WithLabelValues(ing.Addr).Inc()
+		if err != nil {
+			ingesterQueryFailures.WithLabelValues(ing.Addr).Inc()
+			return nil, err
+		}
+		defer stream.CloseSend() //nolint:errcheck
+
+		var metrics []*cardinality.ActiveNativeHistogramMetric
+		for {
+			msg, err := stream.Recv()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				if !grpc_util.IsGRPCContextCanceled(err) {
+					ingesterQueryFailures.WithLabelValues(ing.Addr).Inc()
+				}
+				return nil, err
+			}
+
+			for _, hist := range msg.NativeHistogram {
+				metrics = append(metrics, &cardinality.ActiveNativeHistogramMetric{
+					Labels:      client.FromLabelAdaptersToLabels(hist.Metric),
+					BucketCount: hist.BucketCount,
+				})
+			}
+		}
+		return metrics, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sets := make([][]*cardinality.ActiveNativeHistogramMetric, len(results))
+	for i, r := range results {
+		sets[i] = r.([]*cardinality.ActiveNativeHistogramMetric)
+	}
+	return mergeActiveNativeHistogramMetrics(sets), nil
+}
+
+// mergeActiveNativeHistogramMetrics merges zero or more per-ingester/per-replication-set
+// metric slices into a single list deduplicated by label key. Every ingester reporting a
+// given series is a replica of the same head series rather than holding a disjoint shard
+// of it, so replicas report identical bucket counts for that series - on a duplicate
+// label key the first value seen is kept rather than summed, since summing would inflate
+// the count by roughly the replication factor.
+func mergeActiveNativeHistogramMetrics(sets [][]*cardinality.ActiveNativeHistogramMetric) []*cardinality.ActiveNativeHistogramMetric {
+	seen := map[string]*cardinality.ActiveNativeHistogramMetric{}
+	for _, set := range sets {
+		for _, metric := range set {
+			key := metric.Labels.String()
+			if _, ok := seen[key]; !ok {
+				seen[key] = metric
+			}
+		}
+	}
+
+	metrics := make([]*cardinality.ActiveNativeHistogramMetric, 0, len(seen))
+	for _, m := range seen {
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// ActiveSeriesHandler exposes Distributor.ActiveSeries over HTTP, accepting a `selector`
+// parameter as either a GET query parameter or a form-encoded POST body. It's mounted at
+// /api/v1/cardinality/active_series.
+func (d *Distributor) ActiveSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	matchers, err := cardinality.ParseSelector(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := d.ActiveSeries(r.Context(), matchers...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cardinality.WriteActiveSeriesResponse(w, resp)
+}
+
+// ActiveNativeHistogramMetricsHandler exposes Distributor.ActiveNativeHistogramMetrics
+// over HTTP, accepting a `selector` parameter as either a GET query parameter or a
+// form-encoded POST body. It's mounted at
+// /api/v1/cardinality/active_native_histogram_metrics.
+func (d *Distributor) ActiveNativeHistogramMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	matchers, err := cardinality.ParseSelector(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := d.ActiveNativeHistogramMetrics(r.Context(), matchers...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cardinality.WriteActiveNativeHistogramMetricsResponse(w, resp)
+}
+
+// RegisterActiveSeriesAPI mounts the cardinality endpoints backed by ActiveSeriesHandler
+// and ActiveNativeHistogramMetricsHandler on router, at /api/v1/cardinality/active_series
+// and /api/v1/cardinality/active_native_histogram_metrics respectively. It should be
+// called once alongside the rest of the distributor's HTTP API registration.
+func (d *Distributor) RegisterActiveSeriesAPI(router *mux.Router) {
+	router.Path("/api/v1/cardinality/active_series").Methods("GET", "POST").HandlerFunc(d.ActiveSeriesHandler)
+	router.Path("/api/v1/cardinality/active_native_histogram_metrics").Methods("GET", "POST").HandlerFunc(d.ActiveNativeHistogramMetricsHandler)
+}
@@ -0,0 +1,64 @@
+package distributor
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cortexproject/cortex/pkg/cardinality"
+)
+
+func TestDedupeLabelSets(t *testing.T) {
+	a := labels.Labels{{Name: "__name__", Value: "up"}}
+	b := labels.Labels{{Name: "__name__", Value: "down"}}
+
+	t.Run("merges disjoint sets", func(t *testing.T) {
+		merged := dedupeLabelSets([][]labels.Labels{{a}, {b}})
+		assert.Len(t, merged, 2)
+	})
+
+	t.Run("dedupes the same series reported by multiple ingesters", func(t *testing.T) {
+		// Every replica of a series reports the same label set, so reporting it
+		// from more than one ingester (or replication set) must collapse to one.
+		merged := dedupeLabelSets([][]labels.Labels{{a}, {a}, {a, b}})
+		assert.Len(t, merged, 2)
+	})
+
+	t.Run("handles no sets", func(t *testing.T) {
+		assert.Empty(t, dedupeLabelSets(nil))
+	})
+}
+
+func TestMergeActiveNativeHistogramMetrics(t *testing.T) {
+	lbls := labels.Labels{{Name: "__name__", Value: "http_request_duration_seconds"}}
+
+	t.Run("keeps the bucket count instead of summing across replicas", func(t *testing.T) {
+		// Three ingesters reporting the same head series (RF=3) each report the same
+		// bucket count for it; the merged result must reflect that count once, not
+		// three times over.
+		sets := [][]*cardinality.ActiveNativeHistogramMetric{
+			{{Labels: lbls, BucketCount: 7}},
+			{{Labels: lbls, BucketCount: 7}},
+			{{Labels: lbls, BucketCount: 7}},
+		}
+
+		merged := mergeActiveNativeHistogramMetrics(sets)
+
+		require := assert.New(t)
+		require.Len(merged, 1)
+		require.Equal(sets[0][0].BucketCount, merged[0].BucketCount)
+	})
+
+	t.Run("merges distinct series across sets", func(t *testing.T) {
+		other := labels.Labels{{Name: "__name__", Value: "grpc_request_duration_seconds"}}
+		sets := [][]*cardinality.ActiveNativeHistogramMetric{
+			{{Labels: lbls, BucketCount: 5}},
+			{{Labels: other, BucketCount: 9}},
+		}
+
+		merged := mergeActiveNativeHistogramMetrics(sets)
+
+		assert.Len(t, merged, 2)
+	})
+}
@@ -0,0 +1,76 @@
+package distributor
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/ring"
+)
+
+func TestMergeMatrices(t *testing.T) {
+	metric := model.Metric{"__name__": "up"}
+
+	a := model.Matrix{{
+		Metric: metric,
+		Values: []model.SamplePair{{Timestamp: 100, Value: 1}, {Timestamp: 200, Value: 2}},
+	}}
+	// Two partitions' replica sets both reporting the same series (as can happen at
+	// a partition boundary) should merge into one sample stream, not a duplicate.
+	b := model.Matrix{{
+		Metric: metric,
+		Values: []model.SamplePair{{Timestamp: 200, Value: 2}, {Timestamp: 300, Value: 3}},
+	}}
+
+	merged := mergeMatrices([]interface{}{a, b})
+
+	assert.Len(t, merged, 1)
+	assert.Equal(t, []model.SamplePair{
+		{Timestamp: 100, Value: 1},
+		{Timestamp: 200, Value: 2},
+		{Timestamp: 300, Value: 3},
+	}, merged[0].Values)
+}
+
+func TestForReplicationSets_SingleSetCallsFnDirectly(t *testing.T) {
+	sets := []ring.ReplicationSet{{MaxErrors: 0}}
+
+	var calls int
+	results, err := forReplicationSets(context.Background(), sets, func(ctx context.Context, set ring.ReplicationSet) (interface{}, error) {
+		calls++
+		return "result", nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, []interface{}{"result"}, results)
+}
+
+func TestForReplicationSets_FansOutAcrossPartitions(t *testing.T) {
+	sets := make([]ring.ReplicationSet, 4)
+
+	results, err := forReplicationSets(context.Background(), sets, func(ctx context.Context, set ring.ReplicationSet) (interface{}, error) {
+		return 1, nil
+	})
+
+	require.NoError(t, err)
+	assert.Len(t, results, len(sets))
+	for _, r := range results {
+		assert.Equal(t, 1, r)
+	}
+}
+
+func TestForReplicationSets_PropagatesError(t *testing.T) {
+	sets := make([]ring.ReplicationSet, 3)
+	boom := errors.New("boom")
+
+	_, err := forReplicationSets(context.Background(), sets, func(ctx context.Context, set ring.ReplicationSet) (interface{}, error) {
+		return nil, boom
+	})
+
+	assert.ErrorIs(t, err, boom)
+}
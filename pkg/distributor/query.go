@@ -2,7 +2,9 @@ package distributor
 
 import (
 	"context"
+	"hash/crc32"
 	"io"
+	"sort"
 	"time"
 
 	"github.com/opentracing/opentracing-go"
@@ -28,12 +30,12 @@ func (d *Distributor) Query(ctx context.Context, from, to model.Time, matchers .
 			return err
 		}
 
-		replicationSet, err := d.GetIngestersForQuery(ctx, matchers...)
+		replicationSets, err := d.GetIngestersForQuery(ctx, matchers...)
 		if err != nil {
 			return err
 		}
 
-		matrix, err = d.queryIngesters(ctx, replicationSet, req)
+		matrix, err = d.queryIngesters(ctx, replicationSets, req)
 		if err != nil {
 			return err
 		}
@@ -46,39 +48,102 @@ func (d *Distributor) Query(ctx context.Context, from, to model.Time, matchers .
 	return matrix, err
 }
 
-// QueryStream multiple ingesters via the streaming interface and returns big ol' set of chunks.
-func (d *Distributor) QueryStream(ctx context.Context, from, to model.Time, matchers ...*labels.Matcher) (*ingester_client.QueryStreamResponse, error) {
-	var result *ingester_client.QueryStreamResponse
-	err := instrument.CollectedRequest(ctx, "Distributor.QueryStream", queryDuration, instrument.ErrorCode, func(ctx context.Context) error {
-		req, err := ingester_client.ToQueryRequest(from, to, matchers)
-		if err != nil {
-			return err
-		}
+// StreamedSeries is a single series yielded by a QueryStreamResult. Exactly one of
+// Chunkseries or Timeseries is set, depending on which wire format the owning ingester
+// used to return it.
+type StreamedSeries struct {
+	Chunkseries *ingester_client.TimeSeriesChunk
+	Timeseries  *ingester_client.TimeSeries
+}
 
-		replicationSet, err := d.GetIngestersForQuery(ctx, matchers...)
-		if err != nil {
-			return err
-		}
+// QueryStreamResult is returned by Distributor.QueryStream. It yields merged,
+// deduplicated series lazily as they're read off the wire from the ingesters, so the
+// distributor doesn't have to buffer the whole response in memory before the querier can
+// start consuming it.
+type QueryStreamResult struct {
+	seriesCh chan StreamedSeries
+	errCh    chan error
+	cancel   context.CancelFunc
+}
+
+func newQueryStreamResult(cancel context.CancelFunc) *QueryStreamResult {
+	return &QueryStreamResult{
+		seriesCh: make(chan StreamedSeries),
+		errCh:    make(chan error, 1),
+		cancel:   cancel,
+	}
+}
 
-		result, err = d.queryIngesterStream(ctx, replicationSet, req)
+// Next blocks until the next series is available. It returns false once the stream is
+// exhausted or an error occurred; call Err to distinguish the two.
+func (r *QueryStreamResult) Next() (StreamedSeries, bool) {
+	series, ok := <-r.seriesCh
+	return series, ok
+}
+
+// Err returns the error that terminated the stream, if any. It should only be called
+// after Next has returned false.
+func (r *QueryStreamResult) Err() error {
+	select {
+	case err := <-r.errCh:
+		return err
+	default:
+		return nil
+	}
+}
+
+// Close releases any resources held by the stream, cancelling outstanding ingester
+// requests if the querier stops consuming early. It is safe to call multiple times.
+func (r *QueryStreamResult) Close() {
+	r.cancel()
+}
+
+// QueryStream multiple ingesters via the streaming interface and returns a
+// QueryStreamResult that yields series lazily as they're merged across the replication
+// set, rather than accumulating the full response in memory on the distributor.
+func (d *Distributor) QueryStream(ctx context.Context, from, to model.Time, matchers ...*labels.Matcher) (*QueryStreamResult, error) {
+	req, err := ingester_client.ToQueryRequest(from, to, matchers)
+	if err != nil {
+		return nil, err
+	}
+	req.StreamingChunksBatchSize = d.cfg.StreamingChunksBatchSize
+
+	replicationSets, err := d.GetIngestersForQuery(ctx, matchers...)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	result := newQueryStreamResult(cancel)
+
+	go func() {
+		defer close(result.seriesCh)
+
+		err := instrument.CollectedRequest(ctx, "Distributor.QueryStream", queryDuration, instrument.ErrorCode, func(ctx context.Context) error {
+			return d.queryIngesterStream(ctx, replicationSets, req, result)
+		})
 		if err != nil {
-			return err
+			result.errCh <- err
 		}
+	}()
 
-		if s := opentracing.SpanFromContext(ctx); s != nil {
-			s.LogKV("chunk-series", len(result.GetChunkseries()), "time-series", len(result.GetTimeseries()))
-		}
-		return nil
-	})
-	return result, err
+	return result, nil
 }
 
-// GetIngestersForQuery returns a replication set including all ingesters that should be queried
-// to fetch series matching input label matchers.
-func (d *Distributor) GetIngestersForQuery(ctx context.Context, matchers ...*labels.Matcher) (ring.ReplicationSet, error) {
+// GetIngestersForQuery returns the replication set(s) that should be queried to fetch
+// series matching input label matchers. Normally this is a single replication set built
+// from the ingesters ring. When a partitions ring is configured (ingest storage mode,
+// where each partition is served by its own independent set of ingesters), one
+// replication set per partition is returned instead, and the caller is expected to query
+// each of them and merge the results.
+func (d *Distributor) GetIngestersForQuery(ctx context.Context, matchers ...*labels.Matcher) ([]ring.ReplicationSet, error) {
 	userID, err := user.ExtractOrgID(ctx)
 	if err != nil {
-		return ring.ReplicationSet{}, err
+		return nil, err
+	}
+
+	if d.partitionsRing != nil {
+		return d.partitionsRing.GetReplicationSetsForOperation(ring.Read)
 	}
 
 	// If shuffle sharding is enabled we should only query ingesters which are
@@ -88,7 +153,11 @@ func (d *Distributor) GetIngestersForQuery(ctx context.Context, matchers ...*lab
 		lookbackPeriod := d.cfg.ShuffleShardingLookbackPeriod
 
 		if shardSize > 0 && lookbackPeriod > 0 {
-			return d.ingestersRing.ShuffleShardWithLookback(userID, shardSize, lookbackPeriod, time.Now()).GetReplicationSetForOperation(ring.Read)
+			set, err := d.ingestersRing.ShuffleShardWithLookback(userID, shardSize, lookbackPeriod, time.Now()).GetReplicationSetForOperation(ring.Read)
+			if err != nil {
+				return nil, err
+			}
+			return []ring.ReplicationSet{set}, nil
 		}
 	}
 
@@ -97,19 +166,32 @@ func (d *Distributor) GetIngestersForQuery(ctx context.Context, matchers ...*lab
 		metricNameMatcher, _, ok := extract.MetricNameMatcherFromMatchers(matchers)
 
 		if ok && metricNameMatcher.Type == labels.MatchEqual {
-			return d.ingestersRing.Get(shardByMetricName(userID, metricNameMatcher.Value), ring.Read, nil)
+			set, err := d.ingestersRing.Get(shardByMetricName(userID, metricNameMatcher.Value), ring.Read, nil)
+			if err != nil {
+				return nil, err
+			}
+			return []ring.ReplicationSet{set}, nil
 		}
 	}
 
-	return d.ingestersRing.GetReplicationSetForOperation(ring.Read)
+	set, err := d.ingestersRing.GetReplicationSetForOperation(ring.Read)
+	if err != nil {
+		return nil, err
+	}
+	return []ring.ReplicationSet{set}, nil
 }
 
-// GetIngestersForMetadata returns a replication set including all ingesters that should be queried
-// to fetch metadata (eg. label names/values or series).
-func (d *Distributor) GetIngestersForMetadata(ctx context.Context) (ring.ReplicationSet, error) {
+// GetIngestersForMetadata returns the replication set(s) that should be queried to fetch
+// metadata (eg. label names/values or series). See GetIngestersForQuery for the
+// partitions ring behaviour.
+func (d *Distributor) GetIngestersForMetadata(ctx context.Context) ([]ring.ReplicationSet, error) {
 	userID, err := user.ExtractOrgID(ctx)
 	if err != nil {
-		return ring.ReplicationSet{}, err
+		return nil, err
+	}
+
+	if d.partitionsRing != nil {
+		return d.partitionsRing.GetReplicationSetsForOperation(ring.Read)
 	}
 
 	// If shuffle sharding is enabled we should only query ingesters which are
@@ -119,18 +201,85 @@ func (d *Distributor) GetIngestersForMetadata(ctx context.Context) (ring.Replica
 		lookbackPeriod := d.cfg.ShuffleShardingLookbackPeriod
 
 		if shardSize > 0 && lookbackPeriod > 0 {
-			return d.ingestersRing.ShuffleShardWithLookback(userID, shardSize, lookbackPeriod, time.Now()).GetReplicationSetForOperation(ring.Read)
+			set, err := d.ingestersRing.ShuffleShardWithLookback(userID, shardSize, lookbackPeriod, time.Now()).GetReplicationSetForOperation(ring.Read)
+			if err != nil {
+				return nil, err
+			}
+			return []ring.ReplicationSet{set}, nil
+		}
+	}
+
+	set, err := d.ingestersRing.GetReplicationSetForOperation(ring.Read)
+	if err != nil {
+		return nil, err
+	}
+	return []ring.ReplicationSet{set}, nil
+}
+
+// forReplicationSets fans fn out across every replication set in sets in parallel,
+// collecting each set's result. It's the fan-out point used to query one ingester (or
+// quorum of ingesters) per partition when a partitions ring is in use; with a single
+// replication set it just calls fn directly.
+func forReplicationSets(ctx context.Context, sets []ring.ReplicationSet, fn func(ctx context.Context, set ring.ReplicationSet) (interface{}, error)) ([]interface{}, error) {
+	if len(sets) == 1 {
+		res, err := fn(ctx, sets[0])
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{res}, nil
+	}
+
+	type indexedResult struct {
+		idx int
+		res interface{}
+		err error
+	}
+
+	resultCh := make(chan indexedResult, len(sets))
+	for i, set := range sets {
+		go func(i int, set ring.ReplicationSet) {
+			res, err := fn(ctx, set)
+			resultCh <- indexedResult{idx: i, res: res, err: err}
+		}(i, set)
+	}
+
+	results := make([]interface{}, len(sets))
+	var firstErr error
+	for range sets {
+		ir := <-resultCh
+		if ir.err != nil {
+			if firstErr == nil {
+				firstErr = ir.err
+			}
+			continue
 		}
+		results[ir.idx] = ir.res
+	}
+	if firstErr != nil {
+		return nil, firstErr
 	}
+	return results, nil
+}
 
-	return d.ingestersRing.GetReplicationSetForOperation(ring.Read)
+// queryIngesters queries the ingesters via the older, sample-based API, fanning out
+// across one replication set per partition when a partitions ring is in use.
+func (d *Distributor) queryIngesters(ctx context.Context, replicationSets []ring.ReplicationSet, req *client.QueryRequest) (model.Matrix, error) {
+	perSetResults, err := forReplicationSets(ctx, replicationSets, func(ctx context.Context, set ring.ReplicationSet) (interface{}, error) {
+		return d.queryIngestersForSet(ctx, set, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeMatrices(perSetResults), nil
 }
 
-// queryIngesters queries the ingesters via the older, sample-based API.
-func (d *Distributor) queryIngesters(ctx context.Context, replicationSet ring.ReplicationSet, req *client.QueryRequest) (model.Matrix, error) {
-	// Fetch samples from multiple ingesters in parallel, using the replicationSet
-	// to deal with consistency.
-	results, err := replicationSet.Do(ctx, d.cfg.ExtraQueryDelay, func(ctx context.Context, ing *ring.IngesterDesc) (interface{}, error) {
+// queryIngestersForSet queries every ingester in a single replication set via the
+// sample-based API and merges their results into one matrix.
+func (d *Distributor) queryIngestersForSet(ctx context.Context, replicationSet ring.ReplicationSet, req *client.QueryRequest) (model.Matrix, error) {
+	// Fetch samples from the minimum number of ingesters needed to reach quorum,
+	// hedging across zones rather than always querying every ingester.
+	results, err := d.doUntilQuorum(ctx, replicationSet, func(ctx context.Context, ing *ring.IngesterDesc) (interface{}, error) {
 		client, err := d.ingesterPool.GetClientFor(ing.Addr)
 		if err != nil {
 			return nil, err
@@ -139,7 +288,9 @@ func (d *Distributor) queryIngesters(ctx context.Context, replicationSet ring.Re
 		resp, err := client.(ingester_client.IngesterClient).Query(ctx, req)
 		ingesterQueries.WithLabelValues(ing.Addr).Inc()
 		if err != nil {
-			ingesterQueryFailures.WithLabelValues(ing.Addr).Inc()
+			if !grpc_util.IsGRPCContextCanceled(err) {
+				ingesterQueryFailures.WithLabelValues(ing.Addr).Inc()
+			}
 			return nil, err
 		}
 
@@ -149,7 +300,12 @@ func (d *Distributor) queryIngesters(ctx context.Context, replicationSet ring.Re
 		return nil, err
 	}
 
-	// Merge the results into a single matrix.
+	return mergeMatrices(results), nil
+}
+
+// mergeMatrices merges zero or more model.Matrix values (boxed as interface{}, as
+// returned by doUntilQuorum/forReplicationSets) into a single deduplicated matrix.
+func mergeMatrices(results []interface{}) model.Matrix {
 	fpToSampleStream := map[model.Fingerprint]*model.SampleStream{}
 	for _, result := range results {
 		for _, ss := range result.(model.Matrix) {
@@ -168,14 +324,42 @@ func (d *Distributor) queryIngesters(ctx context.Context, replicationSet ring.Re
 	for _, ss := range fpToSampleStream {
 		result = append(result, ss)
 	}
+	return result
+}
 
-	return result, nil
+// queryIngesterStream fetches series from the ingesters across one or more replication
+// sets and writes the merged, deduplicated result to result as it becomes available. When
+// multiple replication sets are given (one per partition, in ingest storage mode) they're
+// queried in parallel via forReplicationSets. Series are merged within each set only, not
+// across sets: a series returned by more than one set is written to result once per set.
+//
+// When req.StreamingChunksBatchSize is non-zero it uses the batched "streaming chunks"
+// pull protocol, reading a series index from the ingesters up front and then pulling
+// chunks in batches keyed by series index; this bounds the chunk data the distributor
+// holds at once to one batch, while the label sets of every series in the index are
+// held for the whole query. When it's zero, it
+// falls back to the legacy behaviour of buffering each ingester's full response before
+// merging, for ingesters that don't understand the batched protocol.
+func (d *Distributor) queryIngesterStream(ctx context.Context, replicationSets []ring.ReplicationSet, req *client.QueryRequest, result *QueryStreamResult) error {
+	_, err := forReplicationSets(ctx, replicationSets, func(ctx context.Context, set ring.ReplicationSet) (interface{}, error) {
+		if req.StreamingChunksBatchSize == 0 {
+			return nil, d.queryIngesterStreamLegacy(ctx, set, req, result)
+		}
+		return nil, d.queryIngesterStreamBatched(ctx, set, req, result)
+	})
+	return err
 }
 
-// queryIngesterStream queries the ingesters using the new streaming API.
-func (d *Distributor) queryIngesterStream(ctx context.Context, replicationSet ring.ReplicationSet, req *client.QueryRequest) (*ingester_client.QueryStreamResponse, error) {
-	// Fetch samples from multiple ingesters
-	results, err := replicationSet.Do(ctx, d.cfg.ExtraQueryDelay, func(ctx context.Context, ing *ring.IngesterDesc) (interface{}, error) {
+// queryIngesterStreamLegacy implements the original queryIngesterStream behaviour: it
+// buffers each ingester's full QueryStreamResponse, merges chunk series and time series
+// across the replication set by label key, and then emits the merged series.
+func (d *Distributor) queryIngesterStreamLegacy(ctx context.Context, replicationSet ring.ReplicationSet, req *client.QueryRequest, result *QueryStreamResult) error {
+	// Fetch samples from the minimum number of ingesters needed to reach quorum. If a
+	// hedged request in a slower zone loses the race, doUntilQuorum cancels its context;
+	// this loop is the only thing that ever calls Recv on the stream, so it notices the
+	// cancellation itself (Recv returns an error) and the deferred CloseSend runs as it
+	// unwinds - nothing outside this goroutine ever touches the stream.
+	results, err := d.doUntilQuorum(ctx, replicationSet, func(ctx context.Context, ing *ring.IngesterDesc) (interface{}, error) {
 		client, err := d.ingesterPool.GetClientFor(ing.Addr)
 		if err != nil {
 			return nil, err
@@ -184,14 +368,16 @@ func (d *Distributor) queryIngesterStream(ctx context.Context, replicationSet ri
 
 		stream, err := client.(ingester_client.IngesterClient).QueryStream(ctx, req)
 		if err != nil {
-			ingesterQueryFailures.WithLabelValues(ing.Addr).Inc()
+			if !grpc_util.IsGRPCContextCanceled(err) {
+				ingesterQueryFailures.WithLabelValues(ing.Addr).Inc()
+			}
 			return nil, err
 		}
 		defer stream.CloseSend() //nolint:errcheck
 
-		result := &ingester_client.QueryStreamResponse{}
+		resp := &ingester_client.QueryStreamResponse{}
 		for {
-			resp, err := stream.Recv()
+			msg, err := stream.Recv()
 			if err == io.EOF {
 				break
 			} else if err != nil {
@@ -203,20 +389,20 @@ func (d *Distributor) queryIngesterStream(ctx context.Context, replicationSet ri
 				return nil, err
 			}
 
-			result.Chunkseries = append(result.Chunkseries, resp.Chunkseries...)
-			result.Timeseries = append(result.Timeseries, resp.Timeseries...)
+			resp.Chunkseries = append(resp.Chunkseries, msg.Chunkseries...)
+			resp.Timeseries = append(resp.Timeseries, msg.Timeseries...)
 		}
-		return result, nil
+		return resp, nil
 	})
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	hashToChunkseries := map[string]ingester_client.TimeSeriesChunk{}
 	hashToTimeSeries := map[string]ingester_client.TimeSeries{}
 
-	for _, result := range results {
-		response := result.(*ingester_client.QueryStreamResponse)
+	for _, r := range results {
+		response := r.(*ingester_client.QueryStreamResponse)
 
 		// Parse any chunk series
 		for _, series := range response.Chunkseries {
@@ -241,18 +427,258 @@ func (d *Distributor) queryIngesterStream(ctx context.Context, replicationSet ri
 		}
 	}
 
-	resp := &ingester_client.QueryStreamResponse{
-		Chunkseries: make([]client.TimeSeriesChunk, 0, len(hashToChunkseries)),
-		Timeseries:  make([]client.TimeSeries, 0, len(hashToTimeSeries)),
-	}
 	for _, series := range hashToChunkseries {
-		resp.Chunkseries = append(resp.Chunkseries, series)
+		series := series
+		if d.cfg.ChunkDedup {
+			series.Chunks = dedupeChunks(series.Chunks)
+		}
+		select {
+		case result.seriesCh <- StreamedSeries{Chunkseries: &series}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 	for _, series := range hashToTimeSeries {
-		resp.Timeseries = append(resp.Timeseries, series)
+		series := series
+		select {
+		case result.seriesCh <- StreamedSeries{Timeseries: &series}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// ingesterSeriesIndex tracks, for one ingester, the per-series label sets it reported for
+// the query (received up front via a StreamingSeries message, one *Metric per series)
+// together with that ingester's own streaming client, so that chunks for a given series
+// can be pulled from it later. zone is carried along so the later chunk-batch fetches can
+// still be hedged across zones the same way the index fetch was.
+type ingesterSeriesIndex struct {
+	addr   string
+	zone   string
+	client ingester_client.IngesterClient
+	series []*ingester_client.Metric
+}
+
+// queryIngesterStreamBatched implements the batched "streaming chunks" pull protocol: it
+// asks the ingesters in the replication set (as many as doUntilQuorum needs for quorum)
+// for the label sets of the series they hold, builds a single sorted index of series
+// across those ingesters, and then pulls chunks for that index in batches of
+// req.StreamingChunksBatchSize, merging each series' chunks across replicas as each batch
+// arrives and, when ChunkDedup is enabled, deduplicating them.
+func (d *Distributor) queryIngesterStreamBatched(ctx context.Context, replicationSet ring.ReplicationSet, req *client.QueryRequest, result *QueryStreamResult) error {
+	indexResults, err := d.doUntilQuorum(ctx, replicationSet, func(ctx context.Context, ing *ring.IngesterDesc) (interface{}, error) {
+		c, err := d.ingesterPool.GetClientFor(ing.Addr)
+		if err != nil {
+			return nil, err
+		}
+		ingesterQueries.WithLabelValues(ing.Addr).Inc()
+		ingClient := c.(ingester_client.IngesterClient)
+
+		seriesResp, err := ingClient.StreamingSeries(ctx, req)
+		if err != nil {
+			if !grpc_util.IsGRPCContextCanceled(err) {
+				ingesterQueryFailures.WithLabelValues(ing.Addr).Inc()
+			}
+			return nil, err
+		}
+
+		return &ingesterSeriesIndex{
+			addr:   ing.Addr,
+			zone:   ing.Zone,
+			client: ingClient,
+			series: seriesResp.GetSeries(),
+		}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ingesters := make([]*ingesterSeriesIndex, 0, len(indexResults))
+	for _, r := range indexResults {
+		ingesters = append(ingesters, r.(*ingesterSeriesIndex))
+	}
+
+	// Build a single sorted index of the distinct series labels seen across every
+	// ingester, so every ingester can be asked for the same series by index.
+	unified := buildUnifiedSeriesIndex(ingesters)
+
+	for _, batch := range seriesBatches(unified, int(req.StreamingChunksBatchSize)) {
+		merged, err := d.fetchChunksBatch(ctx, ingesters, replicationSet.MaxErrors, batch)
+		if err != nil {
+			return err
+		}
+
+		for _, series := range merged {
+			series := series
+			select {
+			case result.seriesCh <- StreamedSeries{Chunkseries: &series}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	return nil
+}
+
+// buildUnifiedSeriesIndex merges the per-ingester, per-series label sets reported by
+// StreamingSeries into a single, sorted, deduplicated list of series labels shared across
+// the whole replication set.
+func buildUnifiedSeriesIndex(ingesters []*ingesterSeriesIndex) []labels.Labels {
+	seen := map[string]labels.Labels{}
+	for _, ing := range ingesters {
+		for _, series := range ing.series {
+			lbls := client.FromLabelAdaptersToLabels(series.Labels)
+			seen[lbls.String()] = lbls
+		}
+	}
+
+	unified := make([]labels.Labels, 0, len(seen))
+	for _, lbls := range seen {
+		unified = append(unified, lbls)
+	}
+	util.SortLabels(unified)
+	return unified
+}
+
+// seriesBatches splits unified into consecutive batches of at most batchSize series each,
+// preserving order. The final batch may be smaller. A batchSize <= 0 is treated as "one
+// batch containing everything".
+func seriesBatches(unified []labels.Labels, batchSize int) [][]labels.Labels {
+	if batchSize <= 0 {
+		batchSize = len(unified)
+	}
+	if batchSize == 0 {
+		return nil
+	}
+
+	batches := make([][]labels.Labels, 0, (len(unified)+batchSize-1)/batchSize)
+	for start := 0; start < len(unified); start += batchSize {
+		end := start + batchSize
+		if end > len(unified) {
+			end = len(unified)
+		}
+		batches = append(batches, unified[start:end])
+	}
+	return batches
+}
+
+// fetchChunksBatch pulls chunks for the given batch of series from the ingesters that
+// answered the earlier index call, and merges the chunks for each series across replicas.
+// It goes through doUntilQuorum rather than a flat loop over every ingester, so a single
+// slow or failing ingester on this particular batch doesn't have to stall or fail the
+// whole query: maxErrors (carried over from the replication set used for the index call)
+// lets the batch succeed once enough of these ingesters have answered, the same way the
+// index call itself tolerated failures.
+func (d *Distributor) fetchChunksBatch(ctx context.Context, ingesters []*ingesterSeriesIndex, maxErrors int, batch []labels.Labels) ([]ingester_client.TimeSeriesChunk, error) {
+	adapters := make([]ingester_client.LabelAdapter, len(batch))
+	for i, lbls := range batch {
+		adapters[i] = client.ToLabelAdapters(lbls)
+	}
+
+	byAddr := make(map[string]*ingesterSeriesIndex, len(ingesters))
+	descs := make([]ring.IngesterDesc, len(ingesters))
+	for i, ing := range ingesters {
+		byAddr[ing.addr] = ing
+		descs[i] = ring.IngesterDesc{Addr: ing.addr, Zone: ing.zone}
+	}
+	if maxErrors > len(descs)-1 {
+		maxErrors = len(descs) - 1
+	}
+	if maxErrors < 0 {
+		maxErrors = 0
+	}
+	set := ring.ReplicationSet{Ingesters: descs, MaxErrors: maxErrors}
+
+	results, err := d.doUntilQuorum(ctx, set, func(ctx context.Context, ingDesc *ring.IngesterDesc) (interface{}, error) {
+		ing := byAddr[ingDesc.Addr]
+		chunksResp, err := ing.client.StreamingChunks(ctx, &ingester_client.StreamingChunksRequest{Series: adapters})
+		if err != nil {
+			if !grpc_util.IsGRPCContextCanceled(err) {
+				ingesterQueryFailures.WithLabelValues(ing.addr).Inc()
+			}
+			return nil, err
+		}
+		return chunksResp, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]*ingester_client.StreamingChunksResponse, len(results))
+	for i, r := range results {
+		responses[i] = r.(*ingester_client.StreamingChunksResponse)
+	}
+	return mergeChunkSeries(responses, d.cfg.ChunkDedup), nil
+}
+
+// mergeChunkSeries merges the chunk series returned by one StreamingChunks call per
+// ingester into a single slice, keyed by label set, optionally deduping each series'
+// chunks across replicas.
+func mergeChunkSeries(responses []*ingester_client.StreamingChunksResponse, dedup bool) []ingester_client.TimeSeriesChunk {
+	hashToChunkseries := map[string]ingester_client.TimeSeriesChunk{}
+	for _, resp := range responses {
+		for _, series := range resp.GetChunkseries() {
+			key := client.LabelsToKeyString(client.FromLabelAdaptersToLabels(series.Labels))
+			existing := hashToChunkseries[key]
+			existing.Labels = series.Labels
+			existing.Chunks = append(existing.Chunks, series.Chunks...)
+			hashToChunkseries[key] = existing
+		}
+	}
+
+	merged := make([]ingester_client.TimeSeriesChunk, 0, len(hashToChunkseries))
+	for _, series := range hashToChunkseries {
+		if dedup {
+			series.Chunks = dedupeChunks(series.Chunks)
+		}
+		merged = append(merged, series)
+	}
+	return merged
+}
+
+// dedupeChunks sorts a series' chunks by start time and collapses chunks that are exact
+// duplicates of one another - identical (StartTimestampMs, EndTimestampMs, Encoding,
+// len(Data)), confirmed with a CRC32 of Data computed once per chunk as a cheap
+// disambiguator for the rare case of two chunks with the same range, encoding and length
+// but different data. With RF=3 this typically removes two thirds of the chunks a range query
+// would otherwise have the querier decode.
+func dedupeChunks(chunks []ingester_client.Chunk) []ingester_client.Chunk {
+	if len(chunks) < 2 {
+		return chunks
 	}
 
-	return resp, nil
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].StartTimestampMs < chunks[j].StartTimestampMs
+	})
+
+	type chunkKey struct {
+		start, end int64
+		encoding   int32
+		length     int
+		crc        uint32
+	}
+
+	seen := make(map[chunkKey]struct{}, len(chunks))
+	deduped := chunks[:0]
+	for _, c := range chunks {
+		key := chunkKey{
+			start:    c.StartTimestampMs,
+			end:      c.EndTimestampMs,
+			encoding: int32(c.Encoding),
+			length:   len(c.Data),
+			crc:      crc32.ChecksumIEEE(c.Data),
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		deduped = append(deduped, c)
+	}
+	return deduped
 }
 
 // Merges and dedupes two sorted slices with samples together.
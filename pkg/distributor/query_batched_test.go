@@ -0,0 +1,100 @@
+package distributor
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/assert"
+
+	ingester_client "github.com/cortexproject/cortex/pkg/ingester/client"
+)
+
+func TestBuildUnifiedSeriesIndex(t *testing.T) {
+	a := &ingester_client.Metric{Labels: []ingester_client.LabelAdapter{{Name: "__name__", Value: "up"}}}
+	b := &ingester_client.Metric{Labels: []ingester_client.LabelAdapter{{Name: "__name__", Value: "down"}}}
+
+	t.Run("dedupes a series reported by every replica", func(t *testing.T) {
+		ingesters := []*ingesterSeriesIndex{
+			{addr: "ingester-1", series: []*ingester_client.Metric{a, b}},
+			{addr: "ingester-2", series: []*ingester_client.Metric{a}},
+			{addr: "ingester-3", series: []*ingester_client.Metric{a, b}},
+		}
+
+		unified := buildUnifiedSeriesIndex(ingesters)
+
+		assert.Len(t, unified, 2)
+	})
+
+	t.Run("handles no ingesters", func(t *testing.T) {
+		assert.Empty(t, buildUnifiedSeriesIndex(nil))
+	})
+}
+
+func mkLabelsSlice(n int) []labels.Labels {
+	out := make([]labels.Labels, n)
+	for i := range out {
+		out[i] = labels.Labels{{Name: "__name__", Value: string(rune('a' + i))}}
+	}
+	return out
+}
+
+func TestSeriesBatches(t *testing.T) {
+	t.Run("splits into full batches with a smaller final batch", func(t *testing.T) {
+		batches := seriesBatches(mkLabelsSlice(7), 3)
+
+		assert.Len(t, batches, 3)
+		assert.Len(t, batches[0], 3)
+		assert.Len(t, batches[1], 3)
+		assert.Len(t, batches[2], 1)
+	})
+
+	t.Run("exact multiple of batch size produces no trailing empty batch", func(t *testing.T) {
+		batches := seriesBatches(mkLabelsSlice(6), 3)
+
+		assert.Len(t, batches, 2)
+	})
+
+	t.Run("batch size larger than input produces a single batch", func(t *testing.T) {
+		batches := seriesBatches(mkLabelsSlice(2), 10)
+
+		assert.Len(t, batches, 1)
+		assert.Len(t, batches[0], 2)
+	})
+
+	t.Run("non-positive batch size is treated as unbatched", func(t *testing.T) {
+		batches := seriesBatches(mkLabelsSlice(5), 0)
+
+		assert.Len(t, batches, 1)
+		assert.Len(t, batches[0], 5)
+	})
+
+	t.Run("no series produces no batches", func(t *testing.T) {
+		assert.Empty(t, seriesBatches(nil, 3))
+		assert.Empty(t, seriesBatches(nil, 0))
+	})
+}
+
+func TestMergeChunkSeries(t *testing.T) {
+	lbls := []ingester_client.LabelAdapter{{Name: "__name__", Value: "up"}}
+	chunkA := ingester_client.Chunk{StartTimestampMs: 100, EndTimestampMs: 200, Data: []byte("a")}
+	chunkB := ingester_client.Chunk{StartTimestampMs: 200, EndTimestampMs: 300, Data: []byte("b")}
+
+	responses := []*ingester_client.StreamingChunksResponse{
+		{Chunkseries: []ingester_client.TimeSeriesChunk{{Labels: lbls, Chunks: []ingester_client.Chunk{chunkA}}}},
+		{Chunkseries: []ingester_client.TimeSeriesChunk{{Labels: lbls, Chunks: []ingester_client.Chunk{chunkA, chunkB}}}},
+	}
+
+	t.Run("merges and dedupes chunks for the same series across replicas", func(t *testing.T) {
+		merged := mergeChunkSeries(responses, true)
+
+		assert.Len(t, merged, 1)
+		assert.Len(t, merged[0].Chunks, 2)
+	})
+
+	t.Run("without dedup, keeps every chunk from every replica", func(t *testing.T) {
+		merged := mergeChunkSeries(responses, false)
+
+		assert.Len(t, merged, 1)
+		assert.Len(t, merged[0].Chunks, 3)
+	})
+}
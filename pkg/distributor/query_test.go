@@ -0,0 +1,70 @@
+package distributor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	ingester_client "github.com/cortexproject/cortex/pkg/ingester/client"
+)
+
+func TestDedupeChunks(t *testing.T) {
+	mkChunk := func(start, end int64, encoding int32, data string) ingester_client.Chunk {
+		return ingester_client.Chunk{
+			StartTimestampMs: start,
+			EndTimestampMs:   end,
+			Encoding:         encoding,
+			Data:             []byte(data),
+		}
+	}
+
+	t.Run("removes exact duplicates from different replicas", func(t *testing.T) {
+		a := mkChunk(100, 200, 1, "abc")
+		b := mkChunk(100, 200, 1, "abc")
+		c := mkChunk(200, 300, 1, "def")
+
+		deduped := dedupeChunks([]ingester_client.Chunk{b, a, c})
+
+		assert.Len(t, deduped, 2)
+		assert.Equal(t, int64(100), deduped[0].StartTimestampMs)
+		assert.Equal(t, int64(200), deduped[1].StartTimestampMs)
+	})
+
+	t.Run("keeps chunks with same range but different data", func(t *testing.T) {
+		a := mkChunk(100, 200, 1, "abc")
+		b := mkChunk(100, 200, 1, "xyz")
+
+		deduped := dedupeChunks([]ingester_client.Chunk{a, b})
+
+		assert.Len(t, deduped, 2)
+	})
+
+	t.Run("keeps chunks with same range but different encoding", func(t *testing.T) {
+		a := mkChunk(100, 200, 1, "abc")
+		b := mkChunk(100, 200, 2, "abc")
+
+		deduped := dedupeChunks([]ingester_client.Chunk{a, b})
+
+		assert.Len(t, deduped, 2)
+	})
+
+	t.Run("sorts by start time", func(t *testing.T) {
+		a := mkChunk(300, 400, 1, "c")
+		b := mkChunk(100, 200, 1, "a")
+		c := mkChunk(200, 300, 1, "b")
+
+		deduped := dedupeChunks([]ingester_client.Chunk{a, b, c})
+
+		assert.Equal(t, []int64{100, 200, 300}, []int64{
+			deduped[0].StartTimestampMs,
+			deduped[1].StartTimestampMs,
+			deduped[2].StartTimestampMs,
+		})
+	})
+
+	t.Run("handles fewer than two chunks", func(t *testing.T) {
+		assert.Empty(t, dedupeChunks(nil))
+		single := []ingester_client.Chunk{mkChunk(100, 200, 1, "abc")}
+		assert.Equal(t, single, dedupeChunks(single))
+	})
+}
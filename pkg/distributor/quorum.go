@@ -0,0 +1,169 @@
+package distributor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/ring"
+)
+
+// quorumResult is one ingester's outcome from doUntilQuorum.
+type quorumResult struct {
+	ing *ring.IngesterDesc
+	res interface{}
+	err error
+}
+
+// inFlightRequest tracks a single outstanding doUntilQuorum request so it can be
+// cancelled if it loses the race against a faster zone. done is closed by the request's
+// own goroutine right after fn returns, so abort can wait for fn to actually stop
+// touching whatever resource it holds (e.g. a gRPC stream) before considering the
+// ingester free - fn itself is always the only thing that calls Recv on its stream.
+type inFlightRequest struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// abort cancels the request's context and blocks until its goroutine has returned from
+// fn, so callers never race with fn over a shared resource like a gRPC stream.
+func (f *inFlightRequest) abort() {
+	f.cancel()
+	<-f.done
+}
+
+// selectPrimaryAndHedge splits ingesters into a primary wave, sent immediately, and a
+// hedge wave, sent only if the primary wave hasn't reached quorum fast enough. It groups
+// ingesters by zone and fills the primary wave from within one zone at a time - taking as
+// many ingesters from a zone as needed to reach quorum - before moving on to the next
+// zone, rather than always picking exactly one ingester per zone. That matters because
+// plenty of real clusters have a single zone (or no zone awareness at all): picking one
+// primary ingester regardless of quorum would force every query to wait out the full
+// hedge delay even though unconditionally querying every ingester in that zone was always
+// an option. The primary wave ends up with one ingester per zone only when each zone it
+// draws from holds a single ingester.
+func selectPrimaryAndHedge(ingesters []ring.IngesterDesc, quorum int) (primary, hedge []*ring.IngesterDesc) {
+	byZone := map[string][]*ring.IngesterDesc{}
+	var zoneOrder []string
+	for i := range ingesters {
+		ing := &ingesters[i]
+		if _, ok := byZone[ing.Zone]; !ok {
+			zoneOrder = append(zoneOrder, ing.Zone)
+		}
+		byZone[ing.Zone] = append(byZone[ing.Zone], ing)
+	}
+
+	for _, zone := range zoneOrder {
+		zoneIngesters := byZone[zone]
+		need := quorum - len(primary)
+		switch {
+		case need >= len(zoneIngesters):
+			primary = append(primary, zoneIngesters...)
+		case need > 0:
+			primary = append(primary, zoneIngesters[:need]...)
+			hedge = append(hedge, zoneIngesters[need:]...)
+		default:
+			hedge = append(hedge, zoneIngesters...)
+		}
+	}
+
+	return primary, hedge
+}
+
+// doUntilQuorum fans fn out across replicationSet, but instead of unconditionally
+// querying every ingester, it issues only the minimum number of requests needed to reach
+// read quorum, preferring to fill that quorum from as few zones as possible so a single
+// slow or unavailable zone can't block the query. If quorum isn't reached within
+// d.cfg.ExtraQueryDelay, it hedges by issuing requests to the remaining ingesters too. As
+// soon as quorum is satisfied, any still in-flight requests are cancelled - fn is expected
+// to notice its context has been cancelled (e.g. a gRPC Recv returning an error) and
+// return promptly; doUntilQuorum waits for that before moving on, so it never leaves a
+// goroutine running against a stream nobody's reading from anymore.
+func (d *Distributor) doUntilQuorum(ctx context.Context, replicationSet ring.ReplicationSet, fn func(ctx context.Context, ing *ring.IngesterDesc) (interface{}, error)) ([]interface{}, error) {
+	ingesters := replicationSet.Ingesters
+	quorum := len(ingesters) - replicationSet.MaxErrors
+	if quorum <= 0 {
+		quorum = len(ingesters)
+	}
+
+	primary, hedge := selectPrimaryAndHedge(ingesters, quorum)
+
+	resultCh := make(chan quorumResult, len(ingesters))
+
+	var inFlightMtx sync.Mutex
+	inFlight := map[*ring.IngesterDesc]*inFlightRequest{}
+
+	issue := func(ing *ring.IngesterDesc) {
+		reqCtx, cancel := context.WithCancel(ctx)
+		req := &inFlightRequest{cancel: cancel, done: make(chan struct{})}
+
+		inFlightMtx.Lock()
+		inFlight[ing] = req
+		inFlightMtx.Unlock()
+
+		go func() {
+			defer close(req.done)
+			res, err := fn(reqCtx, ing)
+			resultCh <- quorumResult{ing: ing, res: res, err: err}
+		}()
+	}
+
+	cancelOutstanding := func() {
+		inFlightMtx.Lock()
+		outstanding := make([]*inFlightRequest, 0, len(inFlight))
+		for ing, req := range inFlight {
+			outstanding = append(outstanding, req)
+			delete(inFlight, ing)
+		}
+		inFlightMtx.Unlock()
+
+		for _, req := range outstanding {
+			req.abort()
+		}
+	}
+
+	for _, ing := range primary {
+		issue(ing)
+	}
+
+	hedgeTimer := time.NewTimer(d.cfg.ExtraQueryDelay)
+	defer hedgeTimer.Stop()
+	hedgeIssued := len(hedge) == 0
+
+	results := make([]interface{}, 0, quorum)
+	errs := 0
+
+	for len(results) < quorum {
+		select {
+		case r := <-resultCh:
+			inFlightMtx.Lock()
+			delete(inFlight, r.ing)
+			inFlightMtx.Unlock()
+
+			if r.err != nil {
+				errs++
+				if len(ingesters)-errs < quorum {
+					cancelOutstanding()
+					return nil, r.err
+				}
+				continue
+			}
+			results = append(results, r.res)
+
+		case <-hedgeTimer.C:
+			if !hedgeIssued {
+				hedgeIssued = true
+				for _, ing := range hedge {
+					issue(ing)
+				}
+			}
+
+		case <-ctx.Done():
+			cancelOutstanding()
+			return nil, ctx.Err()
+		}
+	}
+
+	cancelOutstanding()
+	return results, nil
+}
@@ -0,0 +1,93 @@
+package distributor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cortexproject/cortex/pkg/ring"
+)
+
+func ingesterDescs(addrToZone map[string]string) []ring.IngesterDesc {
+	descs := make([]ring.IngesterDesc, 0, len(addrToZone))
+	for addr, zone := range addrToZone {
+		descs = append(descs, ring.IngesterDesc{Addr: addr, Zone: zone})
+	}
+	return descs
+}
+
+func addrs(descs []*ring.IngesterDesc) []string {
+	out := make([]string, 0, len(descs))
+	for _, d := range descs {
+		out = append(out, d.Addr)
+	}
+	return out
+}
+
+func TestSelectPrimaryAndHedge_SingleZone(t *testing.T) {
+	// A deployment with no zone awareness (or a single zone) must still be able to
+	// fill quorum from its primary wave alone, rather than being stuck with one
+	// primary ingester no matter how big quorum is.
+	ingesters := ingesterDescs(map[string]string{
+		"ingester-1": "",
+		"ingester-2": "",
+		"ingester-3": "",
+	})
+
+	primary, hedge := selectPrimaryAndHedge(ingesters, 2)
+
+	assert.Len(t, primary, 2)
+	assert.Len(t, hedge, 1)
+}
+
+func TestSelectPrimaryAndHedge_MultiZoneCoversQuorum(t *testing.T) {
+	// With one ingester per zone, filling quorum zone by zone takes one ingester
+	// from each of as many zones as quorum needs.
+	ingesters := ingesterDescs(map[string]string{
+		"ingester-1": "zone-a",
+		"ingester-2": "zone-b",
+		"ingester-3": "zone-c",
+	})
+
+	primary, hedge := selectPrimaryAndHedge(ingesters, 2)
+
+	assert.Len(t, primary, 2)
+	assert.Len(t, hedge, 1)
+
+	zones := map[string]bool{}
+	for _, a := range addrs(primary) {
+		for _, ing := range ingesters {
+			if ing.Addr == a {
+				zones[ing.Zone] = true
+			}
+		}
+	}
+	assert.Len(t, zones, 2, "primary wave should cover 2 distinct zones")
+}
+
+func TestSelectPrimaryAndHedge_QuorumExceedsSingleZoneSize(t *testing.T) {
+	// Quorum needs more ingesters than the first zone alone provides, so the
+	// primary wave must span into the second zone too.
+	ingesters := ingesterDescs(map[string]string{
+		"ingester-1": "zone-a",
+		"ingester-2": "zone-b",
+		"ingester-3": "zone-b",
+	})
+
+	primary, hedge := selectPrimaryAndHedge(ingesters, 3)
+
+	assert.Len(t, primary, 3)
+	assert.Empty(t, hedge)
+}
+
+func TestSelectPrimaryAndHedge_QuorumEqualsTotal(t *testing.T) {
+	ingesters := ingesterDescs(map[string]string{
+		"ingester-1": "zone-a",
+		"ingester-2": "zone-b",
+	})
+
+	primary, hedge := selectPrimaryAndHedge(ingesters, 2)
+
+	assert.Len(t, primary, 2)
+	assert.Empty(t, hedge)
+}